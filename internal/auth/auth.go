@@ -0,0 +1,136 @@
+// Package auth builds the azcore.TokenCredential shared by both tools, so
+// each can run from a developer laptop, a CI pipeline, an AKS pod with
+// workload identity, or a VM with a managed identity without code changes.
+package auth
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/joho/godotenv"
+)
+
+// Config holds the settings needed to build a credential. ClientID and
+// ClientSecret are only required when AUTH_MODE is "client-secret" (the
+// default, kept for backward compatibility with existing .env deployments).
+type Config struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+}
+
+// NewCredential builds an azcore.TokenCredential selected by the AUTH_MODE
+// env var:
+//   - "" or "client-secret" (default): ClientSecretCredential
+//   - "workload-identity": WorkloadIdentityCredential
+//   - "managed-identity": ManagedIdentityCredential
+//   - "cli": AzureCLICredential
+//   - "device-code": DeviceCodeCredential
+//   - "default" or "chained": a ChainedTokenCredential trying workload
+//     identity, managed identity, environment, then Azure CLI in turn.
+//     Managed identity is only in the chain when IDENTITY_ENDPOINT or
+//     MSI_ENDPOINT is set, so an Azure VM that exposes only IMDS gets no
+//     managed identity from this mode. Device code is
+//     interactive, so it's only added to the chain when AUTH_MODE_ALLOW_DEVICE_CODE=true
+//     is set, matching DefaultAzureCredential's choice to exclude it by default.
+func NewCredential(cfg Config) (azcore.TokenCredential, error) {
+	switch Mode() {
+	case "client-secret":
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+	case "workload-identity":
+		return azidentity.NewWorkloadIdentityCredential(nil)
+	case "managed-identity":
+		return azidentity.NewManagedIdentityCredential(nil)
+	case "cli":
+		return azidentity.NewAzureCLICredential(nil)
+	case "device-code":
+		return azidentity.NewDeviceCodeCredential(nil)
+	case "default", "chained":
+		return newChainedCredential()
+	default:
+		return nil, fmt.Errorf("unsupported AUTH_MODE %q", Mode())
+	}
+}
+
+// newChainedCredential builds the "default"/"chained" AUTH_MODE's
+// ChainedTokenCredential. NewManagedIdentityCredential and
+// NewDeviceCodeCredential rarely fail at construction time even when they'll
+// never actually succeed, so both need to be kept out of the chain unless
+// they're actually usable: managed identity is skipped unless onAzureCompute
+// sees a managed identity endpoint env var (it otherwise probes IMDS and
+// times out before falling through to the next credential), and device code is skipped unless explicitly opted into,
+// since it blocks on an interactive prompt that has no business appearing
+// in an otherwise non-interactive chain.
+func newChainedCredential() (azcore.TokenCredential, error) {
+	var creds []azcore.TokenCredential
+
+	if cred, err := azidentity.NewWorkloadIdentityCredential(nil); err == nil {
+		creds = append(creds, cred)
+	}
+	if onAzureCompute() {
+		if cred, err := azidentity.NewManagedIdentityCredential(nil); err == nil {
+			creds = append(creds, cred)
+		}
+	}
+	if cred, err := azidentity.NewEnvironmentCredential(nil); err == nil {
+		creds = append(creds, cred)
+	}
+	if cred, err := azidentity.NewAzureCLICredential(nil); err == nil {
+		creds = append(creds, cred)
+	}
+	if allowDeviceCode() {
+		if cred, err := azidentity.NewDeviceCodeCredential(nil); err == nil {
+			creds = append(creds, cred)
+		}
+	}
+
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("no credential sources available for chained auth")
+	}
+
+	return azidentity.NewChainedTokenCredential(creds, nil)
+}
+
+// onAzureCompute reports whether a managed identity endpoint is advertised
+// through the IDENTITY_ENDPOINT/MSI_ENDPOINT env vars the managed-identity
+// credentials themselves check for their IMDS alternative. It returns false
+// on compute that offers only IMDS, such as a plain Azure VM.
+// Without this, the chain probes IMDS from a developer laptop on every
+// token request, adding real latency before falling through to Azure CLI.
+func onAzureCompute() bool {
+	return os.Getenv("IDENTITY_ENDPOINT") != "" || os.Getenv("MSI_ENDPOINT") != ""
+}
+
+// allowDeviceCode reports whether AUTH_MODE_ALLOW_DEVICE_CODE opts into
+// adding the interactive DeviceCodeCredential to the chain.
+func allowDeviceCode() bool {
+	return os.Getenv("AUTH_MODE_ALLOW_DEVICE_CODE") == "true"
+}
+
+// Mode returns the configured AUTH_MODE, defaulting to "client-secret" when unset.
+func Mode() string {
+	if mode := os.Getenv("AUTH_MODE"); mode != "" {
+		return mode
+	}
+	return "client-secret"
+}
+
+// RequiresClientSecret reports whether the current AUTH_MODE needs
+// CLIENT_ID/CLIENT_SECRET/TENANT_ID from the environment.
+func RequiresClientSecret() bool {
+	return Mode() == "client-secret"
+}
+
+// LoadDotEnv loads a .env file. Under an identity-providing AUTH_MODE a
+// missing .env file is not an error, since CLIENT_SECRET is not required.
+func LoadDotEnv() error {
+	if err := godotenv.Load(); err != nil {
+		if os.IsNotExist(err) && !RequiresClientSecret() {
+			return nil
+		}
+		return fmt.Errorf("error loading .env file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package mlog is a small structured, leveled logger shared by this repo's
+// tools, built on log/slog. It replaces ad hoc stdlib log.Fatalf calls so
+// operators can pipe output to log aggregators instead of regex-parsing
+// free-form text.
+package mlog
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Fields are structured attributes attached to a log record. This repo's
+// tools commonly set tenant_id, sku, user_principal_name, and http_status.
+type Fields map[string]any
+
+// Logger wraps an *slog.Logger with this package's Fields-based API.
+type Logger struct {
+	slog *slog.Logger
+}
+
+// New builds a Logger using the handler and level selected by the
+// LOG_FORMAT ("json" or "text", default "text") and LOG_LEVEL ("debug",
+// "info", "warn", "error", default "info") environment variables.
+func New() *Logger {
+	opts := &slog.HandlerOptions{Level: levelFromEnv()}
+
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	return &Logger{slog: slog.New(handler)}
+}
+
+func levelFromEnv() slog.Level {
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Debug logs msg at debug level with the given structured fields.
+func (l *Logger) Debug(msg string, fields Fields) { l.log(slog.LevelDebug, msg, fields) }
+
+// Info logs msg at info level with the given structured fields.
+func (l *Logger) Info(msg string, fields Fields) { l.log(slog.LevelInfo, msg, fields) }
+
+// Warn logs msg at warn level with the given structured fields.
+func (l *Logger) Warn(msg string, fields Fields) { l.log(slog.LevelWarn, msg, fields) }
+
+// Error logs msg at error level with the given structured fields.
+func (l *Logger) Error(msg string, fields Fields) { l.log(slog.LevelError, msg, fields) }
+
+func (l *Logger) log(level slog.Level, msg string, fields Fields) {
+	args := make([]any, 0, len(fields)*2)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	l.slog.Log(context.Background(), level, msg, args...)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is a single SKU's last observed availability, stamped with the
+// time it was written so staleness can be checked against the configured TTL.
+type cacheEntry struct {
+	RemainingUnits int       `json:"remainingUnits"`
+	FetchedAt      time.Time `json:"fetchedAt"`
+}
+
+// skuCache is an on-disk cache of SKU availabilities keyed by SKU part
+// number, used to skip paging armcommerce on repeated invocations within
+// ttl. A ttl of zero disables the cache.
+type skuCache struct {
+	path string
+	ttl  time.Duration
+}
+
+func newSkuCache(path string, ttl time.Duration) *skuCache {
+	return &skuCache{path: path, ttl: ttl}
+}
+
+func defaultCachePath() string {
+	return filepath.Join(os.TempDir(), "licenseChecker-sku-cache.json")
+}
+
+// getAll returns cached availabilities for every requested SKU part number,
+// or ok=false if the cache is disabled, the request is the "*" wildcard, or
+// any requested SKU is missing or stale.
+func (c *skuCache) getAll(skuPartNumbers []string) ([]SkuAvailability, bool) {
+	if c.ttl <= 0 || (len(skuPartNumbers) == 1 && skuPartNumbers[0] == "*") {
+		return nil, false
+	}
+
+	entries, err := c.read()
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	availabilities := make([]SkuAvailability, 0, len(skuPartNumbers))
+	for _, sku := range skuPartNumbers {
+		entry, ok := entries[sku]
+		if !ok || now.Sub(entry.FetchedAt) > c.ttl {
+			return nil, false
+		}
+		availabilities = append(availabilities, SkuAvailability{SkuPartNumber: sku, RemainingUnits: entry.RemainingUnits})
+	}
+
+	return availabilities, true
+}
+
+// setAll merges availabilities into the cache, keyed by SKU part number.
+func (c *skuCache) setAll(availabilities []SkuAvailability) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	entries, err := c.read()
+	if err != nil {
+		entries = map[string]cacheEntry{}
+	}
+
+	now := time.Now()
+	for _, a := range availabilities {
+		entries[a.SkuPartNumber] = cacheEntry{RemainingUnits: a.RemainingUnits, FetchedAt: now}
+	}
+
+	_ = c.write(entries)
+}
+
+func (c *skuCache) read() (map[string]cacheEntry, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (c *skuCache) write(entries map[string]cacheEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, data, 0o600)
+}
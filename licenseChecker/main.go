@@ -3,14 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/commerce/armcommerce"
-	"github.com/joho/godotenv"
+
+	"github.com/whitefox82/microsoft_services_tools/internal/auth"
+	"github.com/whitefox82/microsoft_services_tools/internal/mlog"
 )
 
 type SkuAvailability struct {
@@ -18,14 +24,37 @@ type SkuAvailability struct {
 	RemainingUnits int    `json:"remainingUnits"`
 }
 
+// errThresholdBreached signals that one or more SKUs fell below --threshold
+// so main can exit non-zero without treating it as an unexpected failure.
+var errThresholdBreached = errors.New("one or more SKUs are below threshold")
+
+type options struct {
+	skuPartNumbers []string
+	threshold      int
+	watch          bool
+	interval       time.Duration
+	format         string
+	cacheTTL       time.Duration
+	cachePath      string
+}
+
 func main() {
 	if err := run(); err != nil {
+		if errors.Is(err, errThresholdBreached) {
+			os.Exit(1)
+		}
 		log.Fatalf("Error: %v", err)
 	}
 }
 
 func run() error {
-	skuPartNumbers := parseArgs()
+	logger := mlog.New()
+
+	opts, err := parseArgs()
+	if err != nil {
+		return err
+	}
+
 	config, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
@@ -36,20 +65,144 @@ func run() error {
 		return fmt.Errorf("failed to create commerce client: %w", err)
 	}
 
-	availabilities, err := getSkuAvailabilities(client, skuPartNumbers)
+	cache := newSkuCache(opts.cachePath, opts.cacheTTL)
+
+	if !opts.watch {
+		return runOnce(client, cache, logger, opts)
+	}
+
+	return runWatch(client, cache, logger, opts)
+}
+
+func runOnce(client *armcommerce.UsageAggregatesClient, cache *skuCache, logger *mlog.Logger, opts options) error {
+	availabilities, err := getSkuAvailabilities(client, cache, logger, opts.skuPartNumbers)
 	if err != nil {
 		return fmt.Errorf("failed to get SKU availabilities: %w", err)
 	}
 
-	return printJSON(availabilities)
+	alerts := belowThreshold(availabilities, opts.threshold)
+	if err := renderOutput(opts.format, availabilities, alerts); err != nil {
+		return err
+	}
+
+	if len(alerts) > 0 {
+		logger.Warn("SKUs below threshold", mlog.Fields{"threshold": opts.threshold, "alert_count": len(alerts)})
+		return errThresholdBreached
+	}
+
+	return nil
+}
+
+func runWatch(client *armcommerce.UsageAggregatesClient, cache *skuCache, logger *mlog.Logger, opts options) error {
+	var previous map[string]int
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	for {
+		availabilities, err := getSkuAvailabilities(client, cache, logger, opts.skuPartNumbers)
+		if err != nil {
+			return fmt.Errorf("failed to get SKU availabilities: %w", err)
+		}
+
+		alerts := belowThreshold(availabilities, opts.threshold)
+		current := toRemainingByName(availabilities)
+		deltas := diffRemaining(previous, current)
+		if previous == nil || len(deltas) > 0 {
+			if err := renderOutput(opts.format, deltasOrAll(deltas, availabilities, previous == nil), alerts); err != nil {
+				return err
+			}
+		}
+		previous = current
+
+		if len(alerts) > 0 {
+			logger.Warn("SKUs below threshold", mlog.Fields{"threshold": opts.threshold, "alert_count": len(alerts)})
+		}
+
+		<-ticker.C
+	}
+}
+
+// deltasOrAll renders the full snapshot on the first poll, and only the
+// changed SKUs on subsequent polls.
+func deltasOrAll(deltas []SkuAvailability, all []SkuAvailability, first bool) []SkuAvailability {
+	if first {
+		return all
+	}
+	return deltas
+}
+
+func toRemainingByName(availabilities []SkuAvailability) map[string]int {
+	m := make(map[string]int, len(availabilities))
+	for _, a := range availabilities {
+		m[a.SkuPartNumber] = a.RemainingUnits
+	}
+	return m
+}
+
+func diffRemaining(previous, current map[string]int) []SkuAvailability {
+	if previous == nil {
+		return nil
+	}
+
+	var deltas []SkuAvailability
+	for sku, remaining := range current {
+		if previous[sku] != remaining {
+			deltas = append(deltas, SkuAvailability{SkuPartNumber: sku, RemainingUnits: remaining})
+		}
+	}
+
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].SkuPartNumber < deltas[j].SkuPartNumber })
+	return deltas
 }
 
-func parseArgs() []string {
-	if len(os.Args) < 2 {
-		log.Println("Usage: go run . <SKU_PART_NUMBER_1> <SKU_PART_NUMBER_2> ... | * for all SKUs")
-		os.Exit(1)
+func belowThreshold(availabilities []SkuAvailability, threshold int) []SkuAvailability {
+	if threshold <= 0 {
+		return nil
+	}
+
+	var alerts []SkuAvailability
+	for _, a := range availabilities {
+		if a.RemainingUnits < threshold {
+			alerts = append(alerts, a)
+		}
 	}
-	return os.Args[1:]
+	return alerts
+}
+
+func parseArgs() (options, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	threshold := fs.Int("threshold", 0, "exit non-zero and emit alert-shaped output when RemainingUnits is below N for any matched SKU")
+	watch := fs.Bool("watch", false, "poll on --interval and emit deltas instead of exiting after one check")
+	interval := fs.Duration("interval", 30*time.Second, "poll interval used with --watch")
+	format := fs.String("format", "json", "output format: json, prom, or csv")
+	cacheTTL := fs.Duration("cache-ttl", 0, "how long to reuse cached SKU availabilities instead of paging armcommerce (0 disables the cache)")
+	cachePath := fs.String("cache-path", defaultCachePath(), "path to the on-disk SKU availability cache")
+
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return options{}, err
+	}
+
+	skuPartNumbers := fs.Args()
+	if len(skuPartNumbers) == 0 {
+		return options{}, fmt.Errorf("usage: %s [flags] <SKU_PART_NUMBER_1> <SKU_PART_NUMBER_2> ... | *", os.Args[0])
+	}
+
+	switch *format {
+	case "json", "prom", "csv":
+	default:
+		return options{}, fmt.Errorf("invalid --format %q: must be json, prom, or csv", *format)
+	}
+
+	return options{
+		skuPartNumbers: skuPartNumbers,
+		threshold:      *threshold,
+		watch:          *watch,
+		interval:       *interval,
+		format:         *format,
+		cacheTTL:       *cacheTTL,
+		cachePath:      *cachePath,
+	}, nil
 }
 
 type config struct {
@@ -59,8 +212,8 @@ type config struct {
 }
 
 func loadConfig() (*config, error) {
-	if err := godotenv.Load(); err != nil {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
+	if err := auth.LoadDotEnv(); err != nil {
+		return nil, err
 	}
 
 	cfg := &config{
@@ -69,15 +222,18 @@ func loadConfig() (*config, error) {
 		ClientSecret: os.Getenv("CLIENT_SECRET"),
 	}
 
-	if cfg.ClientID == "" || cfg.TenantID == "" || cfg.ClientSecret == "" {
-		return nil, fmt.Errorf("missing required environment variables")
+	if cfg.TenantID == "" {
+		return nil, fmt.Errorf("missing required environment variable TENANT_ID")
+	}
+	if auth.RequiresClientSecret() && (cfg.ClientID == "" || cfg.ClientSecret == "") {
+		return nil, fmt.Errorf("missing required environment variables for AUTH_MODE=client-secret")
 	}
 
 	return cfg, nil
 }
 
 func newCommerceClient(cfg *config) (*armcommerce.UsageAggregatesClient, error) {
-	cred, err := azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+	cred, err := auth.NewCredential(auth.Config{TenantID: cfg.TenantID, ClientID: cfg.ClientID, ClientSecret: cfg.ClientSecret})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create credential: %w", err)
 	}
@@ -90,31 +246,44 @@ func newCommerceClient(cfg *config) (*armcommerce.UsageAggregatesClient, error)
 	return client, nil
 }
 
-func getSkuAvailabilities(client *armcommerce.UsageAggregatesClient, skuPartNumbers []string) ([]SkuAvailability, error) {
+// getSkuAvailabilities returns availabilities for skuPartNumbers, serving
+// from cache when every requested SKU has a fresh entry and otherwise
+// paging armcommerce and refreshing the cache with everything it sees.
+func getSkuAvailabilities(client *armcommerce.UsageAggregatesClient, cache *skuCache, logger *mlog.Logger, skuPartNumbers []string) ([]SkuAvailability, error) {
+	if cached, ok := cache.getAll(skuPartNumbers); ok {
+		logger.Debug("served SKU availabilities from cache", mlog.Fields{"sku_count": len(cached)})
+		return cached, nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	pager := client.NewListPager(nil)
 
-	var availabilities []SkuAvailability
+	var all []SkuAvailability
+	var matched []SkuAvailability
 	for pager.More() {
 		page, err := pager.NextPage(ctx)
 		if err != nil {
+			logger.Error("failed to list SKUs", mlog.Fields{"error": err.Error()})
 			return nil, fmt.Errorf("failed to list SKUs: %w", err)
 		}
 
 		for _, sku := range page.Value {
-			if shouldIncludeSku(skuPartNumbers, *sku.Name) {
-				remaining := int(*sku.Capacity - *sku.UsedCapacity)
-				availabilities = append(availabilities, SkuAvailability{
-					SkuPartNumber:  *sku.Name,
-					RemainingUnits: remaining,
-				})
+			availability := SkuAvailability{
+				SkuPartNumber:  *sku.Name,
+				RemainingUnits: int(*sku.Capacity - *sku.UsedCapacity),
+			}
+			all = append(all, availability)
+			if shouldIncludeSku(skuPartNumbers, availability.SkuPartNumber) {
+				matched = append(matched, availability)
 			}
 		}
 	}
 
-	return availabilities, nil
+	cache.setAll(all)
+
+	return matched, nil
 }
 
 func shouldIncludeSku(skuPartNumbers []string, skuPartNumber string) bool {
@@ -131,7 +300,26 @@ func contains(slice []string, str string) bool {
 	return false
 }
 
-func printJSON(data interface{}) error {
+func renderOutput(format string, availabilities []SkuAvailability, alerts []SkuAvailability) error {
+	switch format {
+	case "prom":
+		return printProm(availabilities, alerts)
+	case "csv":
+		return printCSV(availabilities, alerts)
+	default:
+		return printJSON(availabilities, alerts)
+	}
+}
+
+func printJSON(availabilities []SkuAvailability, alerts []SkuAvailability) error {
+	var data interface{} = availabilities
+	if len(alerts) > 0 {
+		data = struct {
+			Alerts         []SkuAvailability `json:"alerts"`
+			Availabilities []SkuAvailability `json:"availabilities"`
+		}{Alerts: alerts, Availabilities: availabilities}
+	}
+
 	output, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("error marshaling results: %w", err)
@@ -139,3 +327,54 @@ func printJSON(data interface{}) error {
 	fmt.Println(string(output))
 	return nil
 }
+
+// printProm emits m365_sku_remaining for every SKU, plus
+// m365_sku_below_threshold (1 or 0) so a --threshold breach is visible to a
+// scraper even though prom has no place for printJSON's alerts wrapper.
+func printProm(availabilities []SkuAvailability, alerts []SkuAvailability) error {
+	alerting := alertSet(alerts)
+
+	var b strings.Builder
+	for _, a := range availabilities {
+		fmt.Fprintf(&b, "m365_sku_remaining{sku=%q} %d\n", a.SkuPartNumber, a.RemainingUnits)
+	}
+	for _, a := range availabilities {
+		fmt.Fprintf(&b, "m365_sku_below_threshold{sku=%q} %d\n", a.SkuPartNumber, boolToInt(alerting[a.SkuPartNumber]))
+	}
+	fmt.Print(b.String())
+	return nil
+}
+
+// printCSV emits a belowThreshold column alongside remainingUnits so a
+// --threshold breach survives in csv output, not just the process exit code.
+func printCSV(availabilities []SkuAvailability, alerts []SkuAvailability) error {
+	alerting := alertSet(alerts)
+
+	var b strings.Builder
+	b.WriteString("skuPartNumber,remainingUnits,belowThreshold\n")
+	for _, a := range availabilities {
+		b.WriteString(a.SkuPartNumber)
+		b.WriteString(",")
+		b.WriteString(strconv.Itoa(a.RemainingUnits))
+		b.WriteString(",")
+		b.WriteString(strconv.FormatBool(alerting[a.SkuPartNumber]))
+		b.WriteString("\n")
+	}
+	fmt.Print(b.String())
+	return nil
+}
+
+func alertSet(alerts []SkuAvailability) map[string]bool {
+	set := make(map[string]bool, len(alerts))
+	for _, a := range alerts {
+		set[a.SkuPartNumber] = true
+	}
+	return set
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
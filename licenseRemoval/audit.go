@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/whitefox82/microsoft_services_tools/licenseRemoval/graphclient"
+)
+
+// auditEntry is one line appended to the audit log for a single invocation.
+type auditEntry struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Reason    string        `json:"reason,omitempty"`
+	DryRun    bool          `json:"dryRun"`
+	Summaries []userSummary `json:"summaries"`
+}
+
+func defaultAuditLogPath() string {
+	return filepath.Join(os.TempDir(), "licenseRemoval-audit.log")
+}
+
+// appendAudit appends a JSON line recording this invocation's removal plan
+// and outcome to path, so offboarding removals have a traceable history.
+func appendAudit(path string, opts graphclient.RemoveLicensesOptions, summaries []userSummary) error {
+	entry := auditEntry{
+		Timestamp: time.Now().UTC(),
+		Reason:    opts.Reason,
+		DryRun:    opts.DryRun,
+		Summaries: summaries,
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+// Package graphclient wraps the Microsoft Graph SDK for Go for the license
+// operations this tool needs: reading a user's assigned licenses and
+// removing licenses, either one user at a time or in bulk via Graph's
+// $batch endpoint.
+package graphclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	kiotaabs "github.com/microsoft/kiota-abstractions-go"
+	kiotaauth "github.com/microsoft/kiota-authentication-azure-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+
+	"github.com/whitefox82/microsoft_services_tools/internal/auth"
+	"github.com/whitefox82/microsoft_services_tools/internal/mlog"
+)
+
+// graphScopes is the scope requested for the shared TokenCredentialAuthenticationProvider.
+var graphScopes = []string{"https://graph.microsoft.com/.default"}
+
+// maxBatchSize is the number of requests Graph allows in a single $batch call.
+const maxBatchSize = 20
+
+// Config holds the credentials needed to authenticate against Microsoft Graph.
+// Logger is optional; when nil, New creates one via mlog.New().
+type Config struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Logger       *mlog.Logger
+}
+
+// Client wraps the generated Microsoft Graph SDK client for license operations.
+type Client struct {
+	graph    *msgraphsdk.GraphServiceClient
+	logger   *mlog.Logger
+	tenantID string
+}
+
+// New creates a Client authenticated via the credential selected by
+// AUTH_MODE (see internal/auth), shared with the commerce tool.
+func New(cfg Config) (*Client, error) {
+	cred, err := auth.NewCredential(auth.Config{TenantID: cfg.TenantID, ClientID: cfg.ClientID, ClientSecret: cfg.ClientSecret})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential: %w", err)
+	}
+
+	authProvider, err := kiotaauth.NewAzureIdentityAuthenticationProviderWithScopes(cred, graphScopes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth provider: %w", err)
+	}
+
+	adapter, err := msgraphsdk.NewGraphRequestAdapter(authProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create graph request adapter: %w", err)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = mlog.New()
+	}
+
+	return &Client{graph: msgraphsdk.NewGraphServiceClient(adapter), logger: logger, tenantID: cfg.TenantID}, nil
+}
+
+// AssignedLicense is a SKU assigned to a user, along with the service plans
+// disabled on it, so a selective removal can round-trip that preservation.
+type AssignedLicense struct {
+	SkuID         string
+	DisabledPlans []string
+}
+
+// ListAssignedLicenses returns the SKUs currently assigned to a user. It
+// requests only the assignedLicenses field so a run over many UPNs doesn't
+// pull the rest of each user record over the wire.
+func (c *Client) ListAssignedLicenses(ctx context.Context, userPrincipalName string) ([]AssignedLicense, error) {
+	requestConfig := &users.ItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.ItemRequestBuilderGetQueryParameters{
+			Select: []string{"assignedLicenses"},
+		},
+	}
+
+	user, err := c.graph.Users().ByUserId(userPrincipalName).Get(ctx, requestConfig)
+	if err != nil {
+		c.logRequestError("failed to get assigned licenses", userPrincipalName, err)
+		return nil, fmt.Errorf("failed to get assigned licenses for %s: %w", userPrincipalName, err)
+	}
+
+	var assigned []AssignedLicense
+	for _, license := range user.GetAssignedLicenses() {
+		id := license.GetSkuId()
+		if id == nil {
+			continue
+		}
+
+		var disabledPlans []string
+		for _, plan := range license.GetDisabledPlans() {
+			disabledPlans = append(disabledPlans, plan.String())
+		}
+
+		assigned = append(assigned, AssignedLicense{SkuID: id.String(), DisabledPlans: disabledPlans})
+	}
+
+	c.logger.Debug("listed assigned licenses", mlog.Fields{
+		"tenant_id":           c.tenantID,
+		"user_principal_name": userPrincipalName,
+		"sku_count":           len(assigned),
+	})
+
+	return assigned, nil
+}
+
+// RemoveLicenses removes the given SKU IDs from a single user. kept is
+// reasserted in the same call via Graph's addLicenses, with each SKU's
+// DisabledPlans carried over unchanged, so a SKU that PlanRemoval decided to
+// keep doesn't lose its disabled service plans in the round trip.
+func (c *Client) RemoveLicenses(ctx context.Context, userPrincipalName string, skuIDs []string, kept []AssignedLicense) error {
+	body, err := assignLicensePostBody(skuIDs, kept)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.graph.Users().ByUserId(userPrincipalName).AssignLicense().Post(ctx, body, nil); err != nil {
+		c.logRequestError("failed to remove licenses", userPrincipalName, err)
+		return fmt.Errorf("failed to remove licenses for %s: %w", userPrincipalName, err)
+	}
+
+	c.logger.Info("removed licenses", mlog.Fields{
+		"tenant_id":           c.tenantID,
+		"user_principal_name": userPrincipalName,
+		"sku":                 skuIDs,
+	})
+
+	return nil
+}
+
+func assignLicensePostBody(skuIDs []string, kept []AssignedLicense) (*users.ItemAssignLicensePostRequestBody, error) {
+	removeIDs, err := toUUIDs(skuIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	addLicenses, err := toAddLicenses(kept)
+	if err != nil {
+		return nil, err
+	}
+
+	body := users.NewItemAssignLicensePostRequestBody()
+	body.SetAddLicenses(addLicenses)
+	body.SetRemoveLicenses(removeIDs)
+	return body, nil
+}
+
+// toAddLicenses reasserts kept SKUs through the addLicenses payload,
+// preserving each one's DisabledPlans. AssignLicense otherwise treats a SKU
+// absent from both addLicenses and removeLicenses as untouched, but Graph
+// still requires this to carry disabled-plan changes explicitly, so without
+// it a kept SKU's disabled plans would silently drop during any call that
+// also removes other SKUs from the same user.
+func toAddLicenses(kept []AssignedLicense) ([]models.AssignedLicenseable, error) {
+	addLicenses := make([]models.AssignedLicenseable, 0, len(kept))
+	for _, license := range kept {
+		skuID, err := uuid.Parse(license.SkuID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SKU ID %q: %w", license.SkuID, err)
+		}
+
+		disabledPlans, err := toUUIDs(license.DisabledPlans)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disabled plan for SKU %q: %w", license.SkuID, err)
+		}
+
+		assigned := models.NewAssignedLicense()
+		assigned.SetSkuId(&skuID)
+		assigned.SetDisabledPlans(disabledPlans)
+		addLicenses = append(addLicenses, assigned)
+	}
+	return addLicenses, nil
+}
+
+// logRequestError logs a failed Graph call with the HTTP status and
+// request-id header, when the SDK error exposes them, so operators can
+// cite the request-id on a support ticket.
+func (c *Client) logRequestError(msg, userPrincipalName string, err error) {
+	statusCode, requestID := requestContext(err)
+	c.logger.Error(msg, mlog.Fields{
+		"tenant_id":           c.tenantID,
+		"user_principal_name": userPrincipalName,
+		"http_status":         statusCode,
+		"request_id":          requestID,
+		"error":               err.Error(),
+	})
+}
+
+func requestContext(err error) (statusCode int, requestID string) {
+	var apiErr *kiotaabs.ApiError
+	if errors.As(err, &apiErr) {
+		statusCode = apiErr.ResponseStatusCode
+		if apiErr.ResponseHeaders != nil {
+			if values := apiErr.ResponseHeaders.Get("request-id"); len(values) > 0 {
+				requestID = values[0]
+			}
+		}
+	}
+	return statusCode, requestID
+}
+
+// BulkRemoveResult is the per-user outcome of a BulkRemoveLicenses call.
+type BulkRemoveResult struct {
+	UserPrincipalName string `json:"userPrincipalName"`
+	Error             string `json:"error,omitempty"`
+}
+
+// UserRemoval pairs a user with the SKUs to remove for them, and the SKUs to
+// keep (with DisabledPlans intact), in a single BulkRemoveLicenses batch,
+// since different users in the same batch generally have different assigned
+// SKUs to remove and keep.
+type UserRemoval struct {
+	UserPrincipalName string
+	SkuIDs            []string
+	Kept              []AssignedLicense
+}
+
+// BulkRemoveLicenses applies each UserRemoval using Graph's $batch endpoint,
+// up to maxBatchSize requests per HTTP call, and reports a per-user result
+// so a single bad user doesn't fail the whole run. A batch that cannot be
+// built or posted at all records its error against every user in that batch,
+// and the remaining batches are still sent.
+func (c *Client) BulkRemoveLicenses(ctx context.Context, removals []UserRemoval) ([]BulkRemoveResult, error) {
+	var results []BulkRemoveResult
+	for _, batch := range chunkRemovals(removals, maxBatchSize) {
+		batchResults, err := c.removeLicensesBatch(ctx, batch)
+		if err != nil {
+			for _, removal := range batch {
+				results = append(results, BulkRemoveResult{UserPrincipalName: removal.UserPrincipalName, Error: err.Error()})
+			}
+			continue
+		}
+		results = append(results, batchResults...)
+	}
+
+	return results, nil
+}
+
+func (c *Client) removeLicensesBatch(ctx context.Context, removals []UserRemoval) ([]BulkRemoveResult, error) {
+	batchContent := msgraphcore.NewBatchRequestContent()
+	stepUsers := make(map[string]string, len(removals))
+
+	for _, removal := range removals {
+		body, err := assignLicensePostBody(removal.SkuIDs, removal.Kept)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build request body for %s: %w", removal.UserPrincipalName, err)
+		}
+
+		requestInfo, err := c.graph.Users().ByUserId(removal.UserPrincipalName).AssignLicense().ToPostRequestInformation(ctx, body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build batch request for %s: %w", removal.UserPrincipalName, err)
+		}
+
+		step, err := msgraphcore.NewBatchRequestStep(requestInfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build batch step for %s: %w", removal.UserPrincipalName, err)
+		}
+
+		batchContent.AddBatchRequestStep(*step)
+		stepUsers[step.GetId()] = removal.UserPrincipalName
+	}
+
+	resp, err := c.graph.GetBatchRequestBuilder().Post(ctx, batchContent, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post batch request: %w", err)
+	}
+
+	// GetResponseById only returns an error when stepID is absent from the
+	// response, so a sub-request that came back 4xx/5xx still yields
+	// err == nil there. GetStatusCodeById reports each sub-request's actual
+	// HTTP status instead, so a failed removal isn't recorded as a success.
+	results := make([]BulkRemoveResult, 0, len(removals))
+	for stepID, upn := range stepUsers {
+		result := BulkRemoveResult{UserPrincipalName: upn}
+
+		statusCode, err := resp.GetStatusCodeById(stepID)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case statusCode < 200 || statusCode >= 300:
+			result.Error = fmt.Sprintf("unexpected status code: %d", statusCode)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func toUUIDs(ids []string) ([]uuid.UUID, error) {
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SKU ID %q: %w", id, err)
+		}
+		out = append(out, parsed)
+	}
+	return out, nil
+}
+
+func chunkRemovals(items []UserRemoval, size int) [][]UserRemoval {
+	var chunks [][]UserRemoval
+	for size < len(items) {
+		items, chunks = items[size:], append(chunks, items[:size:size])
+	}
+	return append(chunks, items)
+}
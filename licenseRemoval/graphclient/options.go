@@ -0,0 +1,47 @@
+package graphclient
+
+// RemoveLicensesOptions controls which of a user's assigned SKUs a removal
+// operation touches.
+type RemoveLicensesOptions struct {
+	// Only, if non-empty, restricts removal to these SKU IDs. Assigned SKUs
+	// not in this list are kept.
+	Only []string
+	// Except preserves these SKU IDs even if they would otherwise be
+	// removed, e.g. to keep Exchange Online assigned to a shared mailbox.
+	Except []string
+	// DryRun computes the removal plan without calling RemoveLicenses.
+	DryRun bool
+	// Reason is recorded in the audit log, e.g. "offboarding ticket #123".
+	Reason string
+}
+
+// PlanRemoval splits a user's assigned SKUs into the ones RemoveLicensesOptions
+// would remove and the ones it would keep. kept retains each SKU's
+// DisabledPlans so the caller can round-trip them back through RemoveLicenses'
+// addLicenses payload instead of dropping that service-plan state.
+func PlanRemoval(assigned []AssignedLicense, opts RemoveLicensesOptions) (removed []string, kept []AssignedLicense) {
+	only := toSet(opts.Only)
+	except := toSet(opts.Except)
+
+	for _, license := range assigned {
+		if except[license.SkuID] {
+			kept = append(kept, license)
+			continue
+		}
+		if len(only) > 0 && !only[license.SkuID] {
+			kept = append(kept, license)
+			continue
+		}
+		removed = append(removed, license.SkuID)
+	}
+
+	return removed, kept
+}
+
+func toSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
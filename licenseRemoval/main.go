@@ -1,167 +1,207 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"strings"
 
-	"github.com/joho/godotenv"
-	"golang.org/x/oauth2/clientcredentials"
+	"github.com/whitefox82/microsoft_services_tools/internal/auth"
+	"github.com/whitefox82/microsoft_services_tools/internal/mlog"
+	"github.com/whitefox82/microsoft_services_tools/licenseRemoval/graphclient"
 )
 
-// Structs for parsing JSON responses
-type LicenseDetails struct {
-	SkuID string `json:"skuId"`
+// userSummary is the machine-readable outcome of processing one user,
+// suitable for downstream automation to consume. Removed, Kept, and Errors
+// are always non-nil so they marshal as [] rather than null when empty.
+type userSummary struct {
+	User    string   `json:"user"`
+	Removed []string `json:"removed"`
+	Kept    []string `json:"kept"`
+	Errors  []string `json:"errors"`
 }
 
-type AssignedLicensesResponse struct {
-	Value []LicenseDetails `json:"value"`
+func newUserSummary(user string) *userSummary {
+	return &userSummary{
+		User:    user,
+		Removed: []string{},
+		Kept:    []string{},
+		Errors:  []string{},
+	}
 }
 
-type RemoveLicensesRequest struct {
-	AddLicenses    []interface{} `json:"addLicenses"`
-	RemoveLicenses []string      `json:"removeLicenses"`
+func keptSkuIDs(kept []graphclient.AssignedLicense) []string {
+	ids := make([]string, 0, len(kept))
+	for _, license := range kept {
+		ids = append(ids, license.SkuID)
+	}
+	return ids
 }
 
-// Config holds the application configuration
-type Config struct {
-	TenantID     string
-	ClientID     string
-	ClientSecret string
-}
+func main() {
+	logger := mlog.New()
 
-// Client represents the Microsoft Graph API client
-type Client struct {
-	httpClient *http.Client
-	baseURL    string
-}
+	opts, auditLogPath, userPrincipalNames, err := parseArgs()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-// NewClient creates a new Microsoft Graph API client
-func NewClient(config Config) (*Client, error) {
-	ctx := context.Background()
-	conf := &clientcredentials.Config{
-		ClientID:     config.ClientID,
-		ClientSecret: config.ClientSecret,
-		TokenURL:     fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", config.TenantID),
-		Scopes:       []string{"https://graph.microsoft.com/.default"},
+	cfg, err := loadConfig(logger)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	httpClient := conf.Client(ctx)
+	client, err := graphclient.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
 
-	return &Client{
-		httpClient: httpClient,
-		baseURL:    "https://graph.microsoft.com/v1.0",
-	}, nil
-}
+	ctx := context.Background()
+	summaries := processUsers(ctx, client, userPrincipalNames, opts)
 
-// GetAssignedLicenses fetches all assigned licenses (skuIds) for a user
-func (c *Client) GetAssignedLicenses(userPrincipalName string) ([]string, error) {
-	url := fmt.Sprintf("%s/users/%s/assignedLicenses", c.baseURL, userPrincipalName)
+	if err := appendAudit(auditLogPath, opts, summaries); err != nil {
+		log.Fatalf("Error writing audit log: %v", err)
+	}
 
-	resp, err := c.httpClient.Get(url)
+	output, err := json.Marshal(summaries)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get assigned licenses: %w", err)
+		log.Fatalf("Error marshaling results: %v", err)
 	}
-	defer resp.Body.Close()
+	fmt.Println(string(output))
+}
 
-	var licensesResponse AssignedLicensesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&licensesResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
+// processUsers computes a removal plan per user and, unless opts.DryRun is
+// set, applies it: a single user is removed with one RemoveLicenses call,
+// while multiple users are removed together through BulkRemoveLicenses so
+// the run costs one Graph $batch call per maxBatchSize users instead of one
+// HTTP round trip per user.
+func processUsers(ctx context.Context, client *graphclient.Client, userPrincipalNames []string, opts graphclient.RemoveLicensesOptions) []userSummary {
+	summaries := make(map[string]*userSummary, len(userPrincipalNames))
+	var removals []graphclient.UserRemoval
 
-	var skuIDs []string
-	for _, license := range licensesResponse.Value {
-		skuIDs = append(skuIDs, license.SkuID)
-	}
+	for _, upn := range userPrincipalNames {
+		summary := newUserSummary(upn)
+		summaries[upn] = summary
 
-	return skuIDs, nil
-}
+		assigned, err := client.ListAssignedLicenses(ctx, upn)
+		if err != nil {
+			summary.Errors = append(summary.Errors, err.Error())
+			continue
+		}
 
-// RemoveLicenses removes the specified licenses from a user
-func (c *Client) RemoveLicenses(userPrincipalName string, skuIDs []string) error {
-	url := fmt.Sprintf("%s/users/%s/assignLicense", c.baseURL, userPrincipalName)
+		removed, kept := graphclient.PlanRemoval(assigned, opts)
+		summary.Removed = append(summary.Removed, removed...)
+		summary.Kept = append(summary.Kept, keptSkuIDs(kept)...)
 
-	requestBody := RemoveLicensesRequest{
-		AddLicenses:    []interface{}{},
-		RemoveLicenses: skuIDs,
-	}
+		if opts.DryRun || len(removed) == 0 {
+			continue
+		}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %w", err)
+		if len(userPrincipalNames) == 1 {
+			if err := client.RemoveLicenses(ctx, upn, removed, kept); err != nil {
+				summary.Errors = append(summary.Errors, err.Error())
+			}
+			continue
+		}
+
+		removals = append(removals, graphclient.UserRemoval{UserPrincipalName: upn, SkuIDs: removed, Kept: kept})
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	applyBulkRemovals(ctx, client, summaries, removals)
+
+	out := make([]userSummary, 0, len(userPrincipalNames))
+	for _, upn := range userPrincipalNames {
+		out = append(out, *summaries[upn])
 	}
+	return out
+}
 
-	req.Header.Add("Content-Type", "application/json")
+// applyBulkRemovals posts removals through BulkRemoveLicenses and folds each
+// per-user result back into summaries. A failure to post the batch at all is
+// recorded against every user in that batch.
+func applyBulkRemovals(ctx context.Context, client *graphclient.Client, summaries map[string]*userSummary, removals []graphclient.UserRemoval) {
+	if len(removals) == 0 {
+		return
+	}
 
-	resp, err := c.httpClient.Do(req)
+	results, err := client.BulkRemoveLicenses(ctx, removals)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		for _, removal := range removals {
+			summaries[removal.UserPrincipalName].Errors = append(summaries[removal.UserPrincipalName].Errors, err.Error())
+		}
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	for _, result := range results {
+		if result.Error != "" {
+			summaries[result.UserPrincipalName].Errors = append(summaries[result.UserPrincipalName].Errors, result.Error)
+		}
 	}
-
-	return nil
 }
 
-func loadConfig() (Config, error) {
-	if err := godotenv.Load(); err != nil {
-		return Config{}, fmt.Errorf("error loading .env file: %w", err)
+func parseArgs() (graphclient.RemoveLicensesOptions, string, []string, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	only := fs.String("only", "", "comma-separated SKU IDs to remove; all other assigned SKUs are kept")
+	except := fs.String("except", "", "comma-separated SKU IDs to preserve, e.g. to keep Exchange Online for a shared mailbox")
+	dryRun := fs.Bool("dry-run", false, "compute the removal plan and print it without removing any licenses (assigned licenses are still read from Graph)")
+	reason := fs.String("reason", "", "reason recorded in the audit log, e.g. \"offboarding ticket #123\"")
+	auditLog := fs.String("audit-log", defaultAuditLogPath(), "path to the audit log that removal operations are appended to")
+
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return graphclient.RemoveLicensesOptions{}, "", nil, err
 	}
 
-	config := Config{
-		TenantID:     os.Getenv("TENANT_ID"),
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
+	userPrincipalNames := fs.Args()
+	if len(userPrincipalNames) == 0 {
+		return graphclient.RemoveLicensesOptions{}, "", nil, fmt.Errorf("usage: %s [flags] <userPrincipalName> [userPrincipalName...]", os.Args[0])
 	}
 
-	if config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
-		return Config{}, fmt.Errorf("missing required environment variables")
+	opts := graphclient.RemoveLicensesOptions{
+		Only:   splitCSV(*only),
+		Except: splitCSV(*except),
+		DryRun: *dryRun,
+		Reason: *reason,
 	}
 
-	return config, nil
+	return opts, *auditLog, userPrincipalNames, nil
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run . <userPrincipalName>")
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
 	}
-	userPrincipalName := os.Args[1]
 
-	config, err := loadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if trimmed := strings.TrimSpace(p); trimmed != "" {
+			out = append(out, trimmed)
+		}
 	}
+	return out
+}
 
-	client, err := NewClient(config)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+func loadConfig(logger *mlog.Logger) (graphclient.Config, error) {
+	if err := auth.LoadDotEnv(); err != nil {
+		return graphclient.Config{}, err
 	}
 
-	skuIDs, err := client.GetAssignedLicenses(userPrincipalName)
-	if err != nil {
-		log.Fatalf("Error getting assigned licenses: %v", err)
+	cfg := graphclient.Config{
+		TenantID:     os.Getenv("TENANT_ID"),
+		ClientID:     os.Getenv("CLIENT_ID"),
+		ClientSecret: os.Getenv("CLIENT_SECRET"),
+		Logger:       logger,
 	}
 
-	if len(skuIDs) == 0 {
-		fmt.Println("No licenses found to remove.")
-		return
+	if cfg.TenantID == "" {
+		return graphclient.Config{}, fmt.Errorf("missing required environment variable TENANT_ID")
 	}
-
-	if err := client.RemoveLicenses(userPrincipalName, skuIDs); err != nil {
-		log.Fatalf("Error removing licenses: %v", err)
+	if auth.RequiresClientSecret() && (cfg.ClientID == "" || cfg.ClientSecret == "") {
+		return graphclient.Config{}, fmt.Errorf("missing required environment variables for AUTH_MODE=client-secret")
 	}
 
-	fmt.Println("Licenses successfully removed.")
+	return cfg, nil
 }